@@ -0,0 +1,161 @@
+package caddy_resumable_uploads
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens, e.g.:
+//
+//	resumable_uploads {
+//		tmp_dir /var/lib/resumable_uploads
+//		max_size 104857600
+//		expire_after 24h
+//		storage s3 {
+//			bucket my-bucket
+//			region us-east-1
+//			endpoint https://s3.example.com
+//		}
+//		pre_create_hook http://localhost:9000/pre-create
+//		post_receive_hook exec /usr/local/bin/post-receive.sh
+//		post_finish_hook http://localhost:9000/post-finish
+//		post_terminate_hook http://localhost:9000/post-terminate
+//	}
+func (m *Middleware) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "tmp_dir":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.TmpDir = d.Val()
+
+			case "max_size":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				size, err := strconv.ParseInt(d.Val(), 10, 64)
+				if err != nil {
+					return d.Errf("invalid max_size %q: %v", d.Val(), err)
+				}
+				m.MaxSize = size
+
+			case "expire_after":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := time.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid expire_after %q: %v", d.Val(), err)
+				}
+				m.ExpireAfter = dur
+
+			case "storage":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				m.Storage = d.Val()
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					switch d.Val() {
+					case "bucket":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						m.S3Bucket = d.Val()
+					case "region":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						m.S3Region = d.Val()
+					case "endpoint":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						m.S3Endpoint = d.Val()
+					default:
+						return d.ArgErr()
+					}
+				}
+
+			case "pre_create_hook":
+				hook, err := parseHookDirective(d)
+				if err != nil {
+					return err
+				}
+				m.PreCreateHook = hook
+
+			case "post_receive_hook":
+				hook, err := parseHookDirective(d)
+				if err != nil {
+					return err
+				}
+				m.PostReceiveHook = hook
+
+			case "post_finish_hook":
+				hook, err := parseHookDirective(d)
+				if err != nil {
+					return err
+				}
+				m.PostFinishHook = hook
+
+			case "post_terminate_hook":
+				hook, err := parseHookDirective(d)
+				if err != nil {
+					return err
+				}
+				m.PostTerminateHook = hook
+
+			default:
+				return d.ArgErr()
+			}
+		}
+	}
+	return nil
+}
+
+// parseHookDirective parses a hook directive's single argument, either a
+// callback URL or "exec <path>".
+func parseHookDirective(d *caddyfile.Dispenser) (*HookConfig, error) {
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+
+	if d.Val() == "exec" {
+		if !d.NextArg() {
+			return nil, d.ArgErr()
+		}
+		return &HookConfig{Exec: d.Val()}, nil
+	}
+
+	return &HookConfig{URL: d.Val()}, nil
+}
+
+// Validate ensures the configured options are internally consistent.
+func (m *Middleware) Validate() error {
+	switch m.Storage {
+	case "", "file":
+		// nothing extra to check
+	case "s3":
+		if m.S3Bucket == "" {
+			return fmt.Errorf("storage s3 requires a bucket")
+		}
+		if m.TmpDir != "" {
+			return fmt.Errorf("tmp_dir is not used with storage s3; configure one or the other")
+		}
+	default:
+		return fmt.Errorf("unknown storage backend %q", m.Storage)
+	}
+
+	if m.MaxSize < 0 {
+		return fmt.Errorf("max_size must not be negative")
+	}
+	if m.ExpireAfter < 0 {
+		return fmt.Errorf("expire_after must not be negative")
+	}
+
+	return nil
+}
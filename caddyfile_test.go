@@ -0,0 +1,34 @@
+package caddy_resumable_uploads
+
+import (
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddytest"
+)
+
+func TestCaddyfileConfiguresFileStorage(t *testing.T) {
+	tester := caddytest.NewTester(t)
+	tester.InitServer(`
+	{
+		skip_install_trust
+		admin localhost:2999
+		auto_https off
+	}
+	localhost:9080 {
+		resumable_uploads {
+			tmp_dir `+t.TempDir()+`
+			max_size 1048576
+			expire_after 1h
+		}
+	}
+	`, "caddyfile")
+
+	tester.AssertGetResponse("http://localhost:9080/unknown-upload-id", 405, "")
+}
+
+func TestCaddyfileRejectsMixedStorage(t *testing.T) {
+	m := Middleware{Storage: "s3", TmpDir: "/tmp/whatever"}
+	if err := m.Validate(); err == nil {
+		t.Fatal("expected Validate to reject tmp_dir combined with storage s3")
+	}
+}
@@ -0,0 +1,189 @@
+package caddy_resumable_uploads
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// filestore is the default Store implementation. It keeps each upload as a
+// plain file under dir, with a sibling "<id>.incomplete" marker file that is
+// removed once the upload is done. This matches the on-disk layout the
+// module has always used.
+type filestore struct {
+	dir string
+}
+
+// newFilestore returns a Store rooted at dir. dir must already exist.
+func newFilestore(dir string) *filestore {
+	return &filestore{dir: dir}
+}
+
+func (s *filestore) dataPath(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func (s *filestore) incompletePath(id string) string {
+	return filepath.Join(s.dir, id+IncompleteExt)
+}
+
+func (s *filestore) infoPath(id string) string {
+	return filepath.Join(s.dir, id+InfoExt)
+}
+
+func (s *filestore) CreateUpload(id string) (*Upload, error) {
+	if _, err := os.Stat(s.dataPath(id)); err == nil {
+		return nil, os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := os.WriteFile(s.incompletePath(id), nil, 0o644); err != nil {
+		return nil, err
+	}
+
+	return &Upload{ID: id, Offset: 0, IsComplete: false}, nil
+}
+
+func (s *filestore) GetUpload(id string) (*Upload, bool, error) {
+	info, err := os.Stat(s.dataPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	isComplete := true
+	if _, err := os.Stat(s.incompletePath(id)); err == nil {
+		isComplete = false
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+
+	return &Upload{ID: id, Offset: info.Size(), IsComplete: isComplete}, true, nil
+}
+
+func (s *filestore) WriteChunk(id string, offset int64, r io.Reader) (int64, error) {
+	file, err := os.OpenFile(s.dataPath(id), os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return io.Copy(file, r)
+}
+
+func (s *filestore) FinishUpload(id string, offset int64) error {
+	if _, err := os.Stat(s.dataPath(id)); err != nil {
+		return err
+	}
+
+	// Truncate to the offset actually written, rather than trusting the
+	// file's current size: a zero-byte upload (offset 0) must discard any
+	// stale content left behind by a previous, unrelated write to the
+	// same path, and the file's on-disk size at that point is exactly
+	// the stale size, not 0.
+	if err := os.Truncate(s.dataPath(id), offset); err != nil {
+		return err
+	}
+
+	err := os.Remove(s.incompletePath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *filestore) Terminate(id string) error {
+	err := os.Remove(s.dataPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = os.Remove(s.incompletePath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = os.Remove(s.infoPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *filestore) Truncate(id string, length int64) error {
+	return os.Truncate(s.dataPath(id), length)
+}
+
+// ExpireUploads removes incomplete uploads whose ".incomplete" marker
+// hasn't been touched in at least maxAge.
+func (s *filestore) ExpireUploads(maxAge time.Duration) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	threshold := time.Now().Add(-maxAge)
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), IncompleteExt) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(threshold) {
+			continue
+		}
+
+		id := strings.TrimSuffix(entry.Name(), IncompleteExt)
+		if err := s.Terminate(id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *filestore) SetMetadata(id string, metadata map[string]string) error {
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.infoPath(id), body, 0o644)
+}
+
+func (s *filestore) GetMetadata(id string) (map[string]string, error) {
+	body, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{}
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
@@ -0,0 +1,146 @@
+package caddy_resumable_uploads
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestFilestoreRoundTrip(t *testing.T) {
+	s := newFilestore(t.TempDir())
+
+	upload, err := s.CreateUpload("upload-1")
+	if err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+	if upload.Offset != 0 || upload.IsComplete {
+		t.Fatalf("CreateUpload returned %+v, want offset 0 and incomplete", upload)
+	}
+
+	if _, err := s.CreateUpload("upload-1"); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("CreateUpload on an existing id returned %v, want os.ErrExist", err)
+	}
+
+	n, err := s.WriteChunk("upload-1", 0, bytes.NewReader([]byte("hello ")))
+	if err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	if n != 6 {
+		t.Fatalf("WriteChunk wrote %d bytes, want 6", n)
+	}
+
+	n, err = s.WriteChunk("upload-1", 6, bytes.NewReader([]byte("world")))
+	if err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("WriteChunk wrote %d bytes, want 5", n)
+	}
+
+	upload, exists, err := s.GetUpload("upload-1")
+	if err != nil {
+		t.Fatalf("GetUpload: %v", err)
+	}
+	if !exists {
+		t.Fatal("GetUpload reported the upload as not existing")
+	}
+	if upload.Offset != 11 || upload.IsComplete {
+		t.Fatalf("GetUpload returned %+v, want offset 11 and incomplete", upload)
+	}
+
+	if err := s.FinishUpload("upload-1", 11); err != nil {
+		t.Fatalf("FinishUpload: %v", err)
+	}
+
+	upload, exists, err = s.GetUpload("upload-1")
+	if err != nil {
+		t.Fatalf("GetUpload: %v", err)
+	}
+	if !exists || !upload.IsComplete {
+		t.Fatalf("GetUpload returned %+v, want a complete upload", upload)
+	}
+
+	data, err := os.ReadFile(s.dataPath("upload-1"))
+	if err != nil {
+		t.Fatalf("reading stored data: %v", err)
+	}
+	if string(data) != "hello world" {
+		t.Fatalf("stored data = %q, want %q", data, "hello world")
+	}
+}
+
+func TestFilestoreFinishUploadTruncatesZeroByteUpload(t *testing.T) {
+	s := newFilestore(t.TempDir())
+
+	if _, err := s.CreateUpload("upload-2"); err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+
+	// Simulate stale content left behind by a previous, unrelated write to
+	// the same path, as the comment on FinishUpload describes. The caller
+	// (Middleware) knows the real offset is 0, even though the file on
+	// disk is not.
+	if err := os.WriteFile(s.dataPath("upload-2"), []byte("stale"), 0o644); err != nil {
+		t.Fatalf("seeding stale content: %v", err)
+	}
+
+	if err := s.FinishUpload("upload-2", 0); err != nil {
+		t.Fatalf("FinishUpload: %v", err)
+	}
+
+	data, err := os.ReadFile(s.dataPath("upload-2"))
+	if err != nil {
+		t.Fatalf("reading stored data: %v", err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("stored data = %q, want empty after finishing a zero-byte upload", data)
+	}
+}
+
+func TestFilestoreTruncateRollsBackOverflow(t *testing.T) {
+	s := newFilestore(t.TempDir())
+
+	if _, err := s.CreateUpload("upload-3"); err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+	if _, err := s.WriteChunk("upload-3", 0, bytes.NewReader([]byte("0123456789"))); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	if err := s.Truncate("upload-3", 4); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+
+	upload, _, err := s.GetUpload("upload-3")
+	if err != nil {
+		t.Fatalf("GetUpload: %v", err)
+	}
+	if upload.Offset != 4 {
+		t.Fatalf("Offset after Truncate = %d, want 4", upload.Offset)
+	}
+}
+
+func TestFilestoreTerminateRemovesEverything(t *testing.T) {
+	s := newFilestore(t.TempDir())
+
+	if _, err := s.CreateUpload("upload-4"); err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+	if err := s.SetMetadata("upload-4", map[string]string{"filename": "a.txt"}); err != nil {
+		t.Fatalf("SetMetadata: %v", err)
+	}
+
+	if err := s.Terminate("upload-4"); err != nil {
+		t.Fatalf("Terminate: %v", err)
+	}
+
+	if _, exists, err := s.GetUpload("upload-4"); err != nil || exists {
+		t.Fatalf("GetUpload after Terminate = (exists=%v, err=%v), want (false, nil)", exists, err)
+	}
+
+	// Terminate must be idempotent against a since-removed upload.
+	if err := s.Terminate("upload-4"); err != nil {
+		t.Fatalf("second Terminate: %v", err)
+	}
+}
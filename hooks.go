@@ -0,0 +1,109 @@
+package caddy_resumable_uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+// hookEvent is the payload sent to a pre/post-upload hook.
+type hookEvent struct {
+	Type     string            `json:"type"`
+	UploadId string            `json:"uploadId"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	ClientIP string            `json:"clientIp"`
+	Auth     string            `json:"auth,omitempty"`
+}
+
+// hookResponse carries a hook's verdict back to the caller. Only pre-create
+// hooks act on it; other hook points ignore it and log only an error from
+// running the hook itself.
+type hookResponse struct {
+	StatusCode int
+	Body       []byte
+}
+
+// HookConfig configures a single hook point as either an HTTP callback or an
+// exec'd binary. Exactly one of URL or Exec should be set.
+type HookConfig struct {
+	// URL is POSTed a JSON-encoded hookEvent.
+	URL string `json:"url,omitempty"`
+	// Exec is run with the JSON-encoded hookEvent on stdin.
+	Exec string `json:"exec,omitempty"`
+	// Timeout bounds how long the hook is allowed to run. Defaults to 10s.
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// runHook invokes cfg with event and returns its response. A nil cfg is a
+// no-op and reports success.
+func runHook(ctx context.Context, cfg *HookConfig, event hookEvent) (*hookResponse, error) {
+	if cfg == nil {
+		return &hookResponse{StatusCode: http.StatusOK}, nil
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cfg.URL != "":
+		return runHTTPHook(ctx, cfg.URL, body)
+	case cfg.Exec != "":
+		return runExecHook(ctx, cfg.Exec, body)
+	default:
+		return &hookResponse{StatusCode: http.StatusOK}, nil
+	}
+}
+
+func runHTTPHook(ctx context.Context, url string, body []byte) (*hookResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody := new(bytes.Buffer)
+	if _, err := respBody.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+
+	return &hookResponse{StatusCode: resp.StatusCode, Body: respBody.Bytes()}, nil
+}
+
+func runExecHook(ctx context.Context, path string, body []byte) (*hookResponse, error) {
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(body)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return &hookResponse{StatusCode: http.StatusInternalServerError, Body: out}, nil
+		}
+		return nil, fmt.Errorf("running hook %s: %w", path, err)
+	}
+
+	return &hookResponse{StatusCode: http.StatusOK, Body: out}, nil
+}
+
+func (r *hookResponse) isSuccess() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
@@ -0,0 +1,49 @@
+package caddy_resumable_uploads
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// expirer is implemented by stores that can find and remove incomplete
+// uploads that were started a while ago. Stores that don't implement it are
+// silently skipped by the janitor.
+type expirer interface {
+	// ExpireUploads removes any incomplete upload older than maxAge.
+	ExpireUploads(maxAge time.Duration) error
+}
+
+// startJanitor launches the background goroutine that periodically expires
+// abandoned uploads. It is a no-op if ExpireAfter is unset.
+func (m *Middleware) startJanitor() {
+	if m.ExpireAfter <= 0 {
+		return
+	}
+
+	m.janitorDone = make(chan struct{})
+	ticker := time.NewTicker(m.ExpireAfter)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.runJanitor()
+			case <-m.janitorDone:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Middleware) runJanitor() {
+	ex, ok := m.store.(expirer)
+	if !ok {
+		return
+	}
+
+	if err := ex.ExpireUploads(m.ExpireAfter); err != nil {
+		m.logger.Error("janitor", zap.Error(err))
+	}
+}
@@ -2,13 +2,19 @@ package caddy_resumable_uploads
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
@@ -20,9 +26,11 @@ import (
 var (
 	InteropVersion = "4"
 	IncompleteExt  = ".incomplete"
+	InfoExt        = ".info"
 	// Interface guards
 	_ caddy.Provisioner           = (*Middleware)(nil)
 	_ caddy.Validator             = (*Middleware)(nil)
+	_ caddy.CleanerUpper          = (*Middleware)(nil)
 	_ caddyhttp.MiddlewareHandler = (*Middleware)(nil)
 	_ caddyfile.Unmarshaler       = (*Middleware)(nil)
 )
@@ -30,13 +38,46 @@ var (
 func init() {
 	caddy.RegisterModule(Middleware{})
 	httpcaddyfile.RegisterHandlerDirective("resumable_uploads", parseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("resumable_uploads", httpcaddyfile.Before, "file_server")
 }
 
 type Middleware struct {
 	// Internal logger
 	logger *zap.Logger
-	// Path to store temporary files. Defaults to os.TempDir()/resumable_uploads
+	// Internal storage backend, built from the fields below in Provision.
+	store Store
+
+	// Path to store temporary files. Defaults to os.TempDir()/resumable_uploads.
+	// Only used when Storage is empty or "file".
 	TmpDir string `json:"output_path,omitempty"`
+	// Storage selects the Store implementation: "file" (default) or "s3".
+	Storage string `json:"storage,omitempty"`
+	// S3Bucket is the bucket uploads are written to when Storage is "s3".
+	S3Bucket string `json:"s3_bucket,omitempty"`
+	// S3Region is the AWS region of S3Bucket.
+	S3Region string `json:"s3_region,omitempty"`
+	// S3Endpoint overrides the S3 API endpoint, for S3-compatible services.
+	S3Endpoint string `json:"s3_endpoint,omitempty"`
+
+	// MaxSize caps how many bytes a single upload may hold. Zero means
+	// unlimited.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// ExpireAfter is how long an upload may sit incomplete before the
+	// janitor removes it. Zero disables the janitor.
+	ExpireAfter time.Duration `json:"expire_after,omitempty"`
+	// janitorDone stops the janitor goroutine started in Provision.
+	janitorDone chan struct{}
+
+	// PreCreateHook runs before an upload is created. A non-2xx response
+	// aborts creation with that status code and body.
+	PreCreateHook *HookConfig `json:"pre_create_hook,omitempty"`
+	// PostReceiveHook runs after each chunk accepted by UploadAppendingHandler.
+	PostReceiveHook *HookConfig `json:"post_receive_hook,omitempty"`
+	// PostFinishHook runs after an upload is completed.
+	PostFinishHook *HookConfig `json:"post_finish_hook,omitempty"`
+	// PostTerminateHook runs after an upload is terminated.
+	PostTerminateHook *HookConfig `json:"post_terminate_hook,omitempty"`
 }
 
 // ==== Caddy Module Interface ====
@@ -50,24 +91,43 @@ func (Middleware) CaddyModule() caddy.ModuleInfo {
 
 func (m *Middleware) Provision(ctx caddy.Context) error {
 	m.logger = ctx.Logger()
-	if m.TmpDir == "" {
-		m.TmpDir = filepath.Join(os.TempDir(), "resumable_uploads")
+
+	switch m.Storage {
+	case "", "file":
+		if m.TmpDir == "" {
+			m.TmpDir = filepath.Join(os.TempDir(), "resumable_uploads")
+		}
+		m.logger.Info("Provisioning", zap.String("storage", "file"), zap.String("tmpdir", m.TmpDir))
+		if err := os.MkdirAll(m.TmpDir, os.ModePerm); err != nil {
+			return err
+		}
+		m.store = newFilestore(m.TmpDir)
+	case "s3":
+		m.logger.Info("Provisioning", zap.String("storage", "s3"), zap.String("bucket", m.S3Bucket))
+		cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(m.S3Region))
+		if err != nil {
+			return err
+		}
+		client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+			if m.S3Endpoint != "" {
+				o.BaseEndpoint = aws.String(m.S3Endpoint)
+			}
+		})
+		m.store = newS3store(client, m.S3Bucket)
+	default:
+		return fmt.Errorf("unknown storage backend %q", m.Storage)
 	}
-	m.logger.Info("Provisioning", zap.String("tmpdir", m.TmpDir))
-	return os.MkdirAll(m.TmpDir, os.ModePerm)
+
+	m.startJanitor()
+
+	return nil
 }
 
-func (h *Middleware) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
-	// for d.Next() {
-	// 	for d.NextBlock(0) {
-	// 		switch d.Val() {
-	// 		case "location":
-	// 			if !d.Args(&h.Location) {
-	// 				return d.ArgErr()
-	// 			}
-	// 		}
-	// 	}
-	// }
+// Cleanup stops the janitor goroutine started in Provision.
+func (m *Middleware) Cleanup() error {
+	if m.janitorDone != nil {
+		close(m.janitorDone)
+	}
 	return nil
 }
 
@@ -77,16 +137,11 @@ func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error)
 	return m, err
 }
 
-func (m *Middleware) Validate() error {
-	// TODO
-	return nil
-}
-
 func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, PATCH, HEAD, DELETE")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "Upload-Draft-Interop-Version, Upload-Offset, Upload-Complete, Location")
+	w.Header().Set("Access-Control-Expose-Headers", "Upload-Draft-Interop-Version, Upload-Offset, Upload-Complete, Location, Upload-Metadata")
 
 	m.logger.Info("ServeHTTP", zap.String("method", r.Method), zap.String("url", getUrl(r)))
 
@@ -101,6 +156,8 @@ func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddy
 		err = m.OffsetRetrievingHandler(w, r)
 	case http.MethodPatch:
 		err = m.UploadAppendingHandler(w, r)
+	case http.MethodDelete:
+		err = m.UploadTerminationHandler(w, r)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -113,24 +170,58 @@ func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddy
 // ==== Resumable Uploads ====
 
 func (m *Middleware) UploadCreationHandler(w http.ResponseWriter, r *http.Request) error {
-	var uploadId string
-	var file *os.File
-	var err error
+	uploadId := uuid.NewString()
 
-	// Create a new upload.
-	uploadId = uuid.NewString()
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid Upload-Metadata header\n"))
+		return nil
+	}
 
-	// Create file to save uploaded chunks
-	file, err = os.OpenFile(filepath.Join(m.TmpDir, uploadId), os.O_WRONLY|os.O_CREATE, 0o644)
+	hookResp, err := runHook(r.Context(), m.PreCreateHook, hookEvent{
+		Type:     "pre-create",
+		UploadId: uploadId,
+		Metadata: metadata,
+		ClientIP: clientIP(r),
+		Auth:     r.Header.Get("Authorization"),
+	})
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	if !hookResp.isSuccess() {
+		m.logger.Info("UploadCreationHandler rejected by pre-create hook", zap.Int("status", hookResp.StatusCode))
+		w.WriteHeader(hookResp.StatusCode)
+		w.Write(hookResp.Body)
+		return nil
+	}
 
-	// Create file to indicate incompleteness
-	if err := os.WriteFile(filepath.Join(m.TmpDir, uploadId+IncompleteExt), nil, 0o644); err != nil {
+	// uploadId is always freshly generated above, so os.ErrExist here would
+	// mean a UUID collision rather than a client-supplied duplicate id. The
+	// branch is kept because CreateUpload's contract documents ErrExist as
+	// a possible return and filestore is tested against that contract (see
+	// filestore_test.go); it is not expected to trigger in practice.
+	if _, err := m.store.CreateUpload(uploadId); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("upload already exists\n"))
+			return nil
+		}
 		return err
 	}
+	if err := m.store.SetMetadata(uploadId, metadata); err != nil {
+		return err
+	}
+
+	limit, abortStatus, abortMsg := m.resolveLimit(r, 0)
+	if abortStatus != 0 {
+		if err := m.store.Terminate(uploadId); err != nil {
+			return err
+		}
+		w.WriteHeader(abortStatus)
+		w.Write([]byte(abortMsg))
+		return nil
+	}
 
 	uploadUrl := getUrl(r) + uploadId
 	m.logger.Info("UploadCreationHandler", zap.String("uploadUrl", uploadUrl))
@@ -143,16 +234,15 @@ func (m *Middleware) UploadCreationHandler(w http.ResponseWriter, r *http.Reques
 		w.Header().Del("Upload-Draft-Interop-Version")
 	}
 
-	// Copy request body to file
-	_, err = io.Copy(file, r.Body)
+	// Write request body to the store, capped at the resolved limit
+	offset, overflowed, err := m.writeCappedChunk(uploadId, 0, limit, r)
 	if err != nil {
 		return err
 	}
-
-	// Obtain latest offset
-	offset, err := file.Seek(0, io.SeekEnd)
-	if err != nil {
-		return err
+	if overflowed {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte("upload exceeds max size\n"))
+		return nil
 	}
 
 	// Check if upload is done now.
@@ -160,10 +250,19 @@ func (m *Middleware) UploadCreationHandler(w http.ResponseWriter, r *http.Reques
 	// have errored out. So here we can assume the request body reading was successful.
 	isComplete := getUploadComplete(r)
 	if isComplete {
-		// Remove file indicating incompleteness
-		if err := os.Remove(filepath.Join(m.TmpDir, uploadId+IncompleteExt)); err != nil {
+		if err := m.store.FinishUpload(uploadId, offset); err != nil {
 			return err
 		}
+		if _, err := runHook(r.Context(), m.PostFinishHook, hookEvent{
+			Type:     "post-finish",
+			UploadId: uploadId,
+			Offset:   offset,
+			Metadata: metadata,
+			ClientIP: clientIP(r),
+			Auth:     r.Header.Get("Authorization"),
+		}); err != nil {
+			m.logger.Error("post-finish hook", zap.Error(err))
+		}
 	}
 
 	setUploadHeaders(w, isComplete, offset)
@@ -172,11 +271,10 @@ func (m *Middleware) UploadCreationHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (m *Middleware) OffsetRetrievingHandler(w http.ResponseWriter, r *http.Request) error {
-	segments := strings.Split(r.URL.Path, "/")
-	id := segments[len(segments)-1]
+	id := uploadIdFromPath(r)
 
-	file, exists, isComplete, offset, err := m.loadUpload(id)
-	m.logger.Info("OffsetRetrievingHandler", zap.String("id", id), zap.Bool("exists", exists), zap.Bool("isComplete", isComplete), zap.Int64("offset", offset))
+	upload, exists, err := m.store.GetUpload(id)
+	m.logger.Info("OffsetRetrievingHandler", zap.String("id", id), zap.Bool("exists", exists))
 	if err != nil {
 		return err
 	}
@@ -186,18 +284,24 @@ func (m *Middleware) OffsetRetrievingHandler(w http.ResponseWriter, r *http.Requ
 		w.Write([]byte("upload not found\n"))
 		return nil
 	}
-	defer file.Close()
 
-	setUploadHeaders(w, isComplete, offset)
+	metadata, err := m.loadMetadata(id)
+	if err != nil {
+		return err
+	}
+	if len(metadata) > 0 {
+		w.Header().Set("Upload-Metadata", formatUploadMetadata(metadata))
+	}
+
+	setUploadHeaders(w, upload.IsComplete, upload.Offset)
 	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
 func (m *Middleware) UploadAppendingHandler(w http.ResponseWriter, r *http.Request) error {
-	segments := strings.Split(r.URL.Path, "/")
-	id := segments[len(segments)-1]
+	id := uploadIdFromPath(r)
 
-	file, exists, complete_server, offset_server, err := m.loadUpload(id)
+	upload, exists, err := m.store.GetUpload(id)
 	if err != nil {
 		return err
 	}
@@ -206,7 +310,6 @@ func (m *Middleware) UploadAppendingHandler(w http.ResponseWriter, r *http.Reque
 		w.Write([]byte("upload not found\n"))
 		return nil
 	}
-	defer file.Close()
 
 	complete_client := !getUploadComplete(r)
 	offset_client, ok := getUploadOffset(r)
@@ -216,67 +319,182 @@ func (m *Middleware) UploadAppendingHandler(w http.ResponseWriter, r *http.Reque
 		return nil
 	}
 
-	if offset_server != offset_client {
-		setUploadHeaders(w, complete_server, offset_server)
+	if upload.Offset != offset_client {
+		setUploadHeaders(w, upload.IsComplete, upload.Offset)
 		w.WriteHeader(http.StatusConflict)
 		w.Write([]byte("mismatching Upload-Offset value\n"))
 		return nil
 	}
 
-	if complete_server {
-		setUploadHeaders(w, complete_server, offset_server)
+	if upload.IsComplete {
+		setUploadHeaders(w, upload.IsComplete, upload.Offset)
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("upload is already complete\n"))
 		return nil
 	}
 
+	limit, abortStatus, abortMsg := m.resolveLimit(r, upload.Offset)
+	if abortStatus != 0 {
+		w.WriteHeader(abortStatus)
+		w.Write([]byte(abortMsg))
+		return nil
+	}
+
 	// r.Body is always non-nil
-	n, err := io.Copy(file, r.Body)
+	n, overflowed, err := m.writeCappedChunk(id, upload.Offset, limit, r)
+	if err != nil {
+		return err
+	}
+	if overflowed {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte("upload exceeds max size\n"))
+		return nil
+	}
+
+	upload.Offset += n
+
+	metadata, err := m.loadMetadata(id)
 	if err != nil {
 		return err
 	}
 
-	offset_server += n
+	if _, err := runHook(r.Context(), m.PostReceiveHook, hookEvent{
+		Type:     "post-receive",
+		UploadId: id,
+		Offset:   upload.Offset,
+		Metadata: metadata,
+		ClientIP: clientIP(r),
+		Auth:     r.Header.Get("Authorization"),
+	}); err != nil {
+		m.logger.Error("post-receive hook", zap.Error(err))
+	}
 
 	if complete_client {
-		complete_server = true
-		if err := os.Remove(filepath.Join(m.TmpDir, id+IncompleteExt)); err != nil {
+		upload.IsComplete = true
+		if err := m.store.FinishUpload(id, upload.Offset); err != nil {
 			return err
 		}
+		if _, err := runHook(r.Context(), m.PostFinishHook, hookEvent{
+			Type:     "post-finish",
+			UploadId: id,
+			Offset:   upload.Offset,
+			Metadata: metadata,
+			ClientIP: clientIP(r),
+			Auth:     r.Header.Get("Authorization"),
+		}); err != nil {
+			m.logger.Error("post-finish hook", zap.Error(err))
+		}
 	}
 
-	setUploadHeaders(w, complete_server, offset_server)
+	setUploadHeaders(w, upload.IsComplete, upload.Offset)
 	w.WriteHeader(http.StatusCreated)
 	return nil
 }
 
-func (m *Middleware) loadUpload(id string) (file *os.File, exists bool, isComplete bool, offset int64, err error) {
-	file, err = os.OpenFile(filepath.Join(m.TmpDir, id), os.O_WRONLY, 0o644)
-	if errors.Is(err, os.ErrNotExist) {
-		exists = false
-		err = nil
-		return // naked return
-	}
+func (m *Middleware) UploadTerminationHandler(w http.ResponseWriter, r *http.Request) error {
+	id := uploadIdFromPath(r)
+
+	_, exists, err := m.store.GetUpload(id)
 	if err != nil {
-		return
+		return err
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("upload not found\n"))
+		return nil
 	}
 
-	exists = true
-	offset, err = file.Seek(0, io.SeekEnd)
+	metadata, err := m.loadMetadata(id)
 	if err != nil {
-		return
+		return err
+	}
+
+	if err := m.store.Terminate(id); err != nil {
+		return err
 	}
 
-	_, err = os.Stat(filepath.Join(m.TmpDir, id+IncompleteExt))
-	if errors.Is(err, os.ErrNotExist) {
-		isComplete = true
-		err = nil
+	if _, err := runHook(r.Context(), m.PostTerminateHook, hookEvent{
+		Type:     "post-terminate",
+		UploadId: id,
+		Metadata: metadata,
+		ClientIP: clientIP(r),
+		Auth:     r.Header.Get("Authorization"),
+	}); err != nil {
+		m.logger.Error("post-terminate hook", zap.Error(err))
 	}
+
+	m.logger.Info("UploadTerminationHandler", zap.String("id", id))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// resolveLimit works out how many more bytes may be written starting at
+// offset, honoring both a declared Upload-Length and the configured
+// MaxSize. limit is -1 when there is no cap. abortStatus is non-zero when
+// the request must be rejected outright instead of being limited.
+func (m *Middleware) resolveLimit(r *http.Request, offset int64) (limit int64, abortStatus int, abortMsg string) {
+	limit = -1
+
+	if declared := r.Header.Get("Upload-Length"); declared != "" {
+		total, err := strconv.ParseInt(declared, 10, 64)
+		if err != nil || total < offset {
+			return 0, http.StatusBadRequest, "invalid Upload-Length header\n"
+		}
+		if m.MaxSize > 0 && total > m.MaxSize {
+			return 0, http.StatusRequestEntityTooLarge, "Upload-Length exceeds max upload size\n"
+		}
+		limit = total - offset
+	}
+
+	if m.MaxSize > 0 {
+		remaining := m.MaxSize - offset
+		if remaining < 0 {
+			remaining = 0
+		}
+		if limit < 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+
+	return limit, 0, ""
+}
+
+// writeCappedChunk writes r.Body to the store at id starting at offset,
+// never handing the store more than limit bytes (limit < 0 means
+// unlimited). Capping the reader itself - rather than reading past limit
+// and checking afterwards - keeps over-limit bytes from ever reaching the
+// store, whether that is local disk or s3store's in-memory part buffer.
+// If the client tries to send more than limit bytes,
+// overflowed is true and the chunk just written is rolled back via
+// Store.Truncate.
+func (m *Middleware) writeCappedChunk(id string, offset, limit int64, r *http.Request) (n int64, overflowed bool, err error) {
+	var body io.Reader = r.Body
+	if limit >= 0 {
+		body = io.LimitReader(r.Body, limit)
+	}
+
+	n, err = m.store.WriteChunk(id, offset, body)
 	if err != nil {
-		return
+		return 0, false, err
+	}
+
+	if limit >= 0 && n >= limit {
+		var probe [1]byte
+		if c, _ := r.Body.Read(probe[:]); c > 0 {
+			if err := m.store.Truncate(id, offset); err != nil {
+				return n, false, err
+			}
+			return n, true, nil
+		}
 	}
 
-	return
+	return n, false, nil
+}
+
+// uploadIdFromPath extracts the trailing upload id from the request path.
+func uploadIdFromPath(r *http.Request) string {
+	segments := strings.Split(r.URL.Path, "/")
+	return segments[len(segments)-1]
 }
 
 func setUploadHeaders(w http.ResponseWriter, isComplete bool, offset int64) {
@@ -304,6 +522,15 @@ func getUploadOffset(r *http.Request) (int64, bool) {
 	return int64(offset), true
 }
 
+// clientIP returns the requesting client's address, stripped of its port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func getUrl(r *http.Request) string {
 	protocol := "http"
 	path := r.URL.Path
@@ -0,0 +1,129 @@
+package caddy_resumable_uploads
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func newTestMiddleware(t *testing.T, maxSize int64) *Middleware {
+	t.Helper()
+	return &Middleware{
+		logger:  zap.NewNop(),
+		store:   newFilestore(t.TempDir()),
+		MaxSize: maxSize,
+	}
+}
+
+func createTestUpload(t *testing.T, m *Middleware) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/uploads/", nil)
+	w := httptest.NewRecorder()
+	if err := m.UploadCreationHandler(w, req); err != nil {
+		t.Fatalf("UploadCreationHandler: %v", err)
+	}
+	if w.Code != 0 && w.Code != http.StatusOK {
+		t.Fatalf("UploadCreationHandler responded %d, want success", w.Code)
+	}
+
+	location := w.Header().Get("Location")
+	if location == "" {
+		t.Fatal("UploadCreationHandler did not set a Location header")
+	}
+	parts := strings.Split(strings.TrimSuffix(location, "/"), "/")
+	return parts[len(parts)-1]
+}
+
+func TestUploadAppendingHandlerRejectsChunkOverMaxSize(t *testing.T) {
+	m := newTestMiddleware(t, 10)
+	id := createTestUpload(t, m)
+
+	body := strings.NewReader(strings.Repeat("a", 15))
+	req := httptest.NewRequest(http.MethodPatch, "/uploads/"+id, body)
+	req.Header.Set("Upload-Offset", "0")
+	w := httptest.NewRecorder()
+
+	if err := m.UploadAppendingHandler(w, req); err != nil {
+		t.Fatalf("UploadAppendingHandler: %v", err)
+	}
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("UploadAppendingHandler responded %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	upload, exists, err := m.store.GetUpload(id)
+	if err != nil {
+		t.Fatalf("GetUpload: %v", err)
+	}
+	if !exists {
+		t.Fatal("GetUpload reported the upload as gone after a rejected chunk")
+	}
+	if upload.Offset != 0 {
+		t.Fatalf("Offset after a rejected chunk = %d, want 0 (truncated back)", upload.Offset)
+	}
+}
+
+func TestUploadAppendingHandlerAcceptsChunkWithinMaxSize(t *testing.T) {
+	m := newTestMiddleware(t, 10)
+	id := createTestUpload(t, m)
+
+	body := strings.NewReader(strings.Repeat("a", 10))
+	req := httptest.NewRequest(http.MethodPatch, "/uploads/"+id, body)
+	req.Header.Set("Upload-Offset", "0")
+	w := httptest.NewRecorder()
+
+	if err := m.UploadAppendingHandler(w, req); err != nil {
+		t.Fatalf("UploadAppendingHandler: %v", err)
+	}
+	if w.Code != http.StatusCreated {
+		t.Fatalf("UploadAppendingHandler responded %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	upload, exists, err := m.store.GetUpload(id)
+	if err != nil {
+		t.Fatalf("GetUpload: %v", err)
+	}
+	if !exists || !upload.IsComplete || upload.Offset != 10 {
+		t.Fatalf("GetUpload = %+v (exists=%v), want complete upload at offset 10", upload, exists)
+	}
+}
+
+func TestUploadCreationHandlerAbortsOnNonSuccessPreCreateHook(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("not allowed"))
+	}))
+	defer hook.Close()
+
+	dir := t.TempDir()
+	m := &Middleware{
+		logger:        zap.NewNop(),
+		store:         newFilestore(dir),
+		PreCreateHook: &HookConfig{URL: hook.URL},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/uploads/", nil)
+	w := httptest.NewRecorder()
+	if err := m.UploadCreationHandler(w, req); err != nil {
+		t.Fatalf("UploadCreationHandler: %v", err)
+	}
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("UploadCreationHandler responded %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w.Body.String() != "not allowed" {
+		t.Fatalf("UploadCreationHandler body = %q, want %q", w.Body.String(), "not allowed")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("hook-rejected creation left %d file(s) behind, want none", len(entries))
+	}
+}
@@ -0,0 +1,53 @@
+package caddy_resumable_uploads
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// parseUploadMetadata parses an Upload-Metadata header value: a
+// comma-separated list of "key base64value" pairs, with the value omitted
+// for flag-style keys (e.g. "is_confidential").
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if header == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, " ", 2)
+		key := parts[0]
+		if len(parts) == 1 {
+			metadata[key] = ""
+			continue
+		}
+
+		value, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// formatUploadMetadata encodes metadata back into Upload-Metadata header form.
+func formatUploadMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// loadMetadata is a thin wrapper around the Store for callers that only
+// care about metadata, such as HEAD responses and hook events.
+func (m *Middleware) loadMetadata(id string) (map[string]string, error) {
+	return m.store.GetMetadata(id)
+}
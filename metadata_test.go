@@ -0,0 +1,83 @@
+package caddy_resumable_uploads
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "empty header",
+			header: "",
+			want:   map[string]string{},
+		},
+		{
+			name:   "single value pair",
+			header: "filename d29ybGQudHh0",
+			want:   map[string]string{"filename": "world.txt"},
+		},
+		{
+			name:   "flag-style key without a value",
+			header: "is_confidential",
+			want:   map[string]string{"is_confidential": ""},
+		},
+		{
+			name:   "multiple pairs with surrounding whitespace",
+			header: "filename d29ybGQudHh0, is_confidential, filetype dGV4dC9wbGFpbg==",
+			want: map[string]string{
+				"filename":        "world.txt",
+				"is_confidential": "",
+				"filetype":        "text/plain",
+			},
+		},
+		{
+			name:    "invalid base64 value",
+			header:  "filename not-valid-base64!!!",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseUploadMetadata(tt.header)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseUploadMetadata(%q) = nil error, want one", tt.header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseUploadMetadata(%q): %v", tt.header, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseUploadMetadata(%q) = %#v, want %#v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatUploadMetadataRoundTrip(t *testing.T) {
+	tests := []map[string]string{
+		{},
+		{"filename": "world.txt"},
+		{"is_confidential": ""},
+		{"filename": "world.txt", "is_confidential": "", "filetype": "text/plain"},
+	}
+
+	for _, want := range tests {
+		header := formatUploadMetadata(want)
+		got, err := parseUploadMetadata(header)
+		if err != nil {
+			t.Fatalf("parseUploadMetadata(formatUploadMetadata(%#v)) = %q: %v", want, header, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("round trip of %#v produced %#v (header %q)", want, got, header)
+		}
+	}
+}
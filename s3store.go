@@ -0,0 +1,401 @@
+package caddy_resumable_uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// minPartSize is the smallest part S3 accepts for all but the last part of
+// a multipart upload.
+const minPartSize = 5 * 1024 * 1024
+
+// s3Info is the bookkeeping persisted as "<id>.info" in the bucket so that
+// an upload can be resumed from any node.
+type s3Info struct {
+	UploadId string                `json:"uploadId"`
+	Parts    []types.CompletedPart `json:"parts"`
+	Offset   int64                 `json:"offset"`
+	Metadata map[string]string     `json:"metadata,omitempty"`
+}
+
+// s3API is the subset of *s3.Client that s3store depends on, narrowed so
+// tests can substitute a fake instead of talking to a real bucket.
+type s3API interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error)
+}
+
+// s3store is a Store backed by S3 Multipart Upload. Incoming PATCH bodies
+// are buffered in memory; once the buffer holds at least minPartSize it is
+// shipped out as a part via UploadPart at the start of the next WriteChunk
+// call, and whatever remains is shipped on FinishUpload. This keeps the
+// module usable in horizontally scaled deployments where local disk
+// doesn't persist across nodes, at the cost of needing request affinity
+// while a part is being buffered - the same tradeoff tusd's S3Store makes.
+type s3store struct {
+	client s3API
+	bucket string
+
+	mu      sync.Mutex
+	buffers map[string]*bytes.Buffer
+}
+
+func newS3store(client s3API, bucket string) *s3store {
+	return &s3store{
+		client:  client,
+		bucket:  bucket,
+		buffers: make(map[string]*bytes.Buffer),
+	}
+}
+
+func (s *s3store) infoKey(id string) string {
+	return id + ".info"
+}
+
+func (s *s3store) getInfo(ctx context.Context, id string) (*s3Info, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.infoKey(id)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	var info s3Info
+	if err := json.NewDecoder(out.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (s *s3store) putInfo(ctx context.Context, id string, info *s3Info) error {
+	body, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.infoKey(id)),
+		Body:   bytes.NewReader(body),
+	})
+	return err
+}
+
+func (s *s3store) CreateUpload(id string) (*Upload, error) {
+	ctx := context.Background()
+
+	if _, err := s.getInfo(ctx, id); err == nil {
+		return nil, os.ErrExist
+	}
+
+	out, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(id),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := &s3Info{UploadId: aws.ToString(out.UploadId)}
+	if err := s.putInfo(ctx, id, info); err != nil {
+		return nil, err
+	}
+
+	return &Upload{ID: id, Offset: 0, IsComplete: false}, nil
+}
+
+func (s *s3store) GetUpload(id string) (*Upload, bool, error) {
+	ctx := context.Background()
+
+	info, err := s.getInfo(ctx, id)
+	if isNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Completed uploads no longer have a pending multipart UploadId.
+	isComplete := info.UploadId == ""
+
+	return &Upload{ID: id, Offset: info.Offset, IsComplete: isComplete}, true, nil
+}
+
+func (s *s3store) WriteChunk(id string, offset int64, r io.Reader) (int64, error) {
+	ctx := context.Background()
+
+	info, err := s.getInfo(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	buf, ok := s.buffers[id]
+	if !ok {
+		buf = new(bytes.Buffer)
+		s.buffers[id] = buf
+	}
+	s.mu.Unlock()
+
+	// Flush whatever was already buffered from a *previous* call now,
+	// before appending this call's bytes - not after. Truncate may still
+	// need to roll this call's bytes back in full (e.g. a chunk that
+	// turns out to exceed the configured max size), and a part already
+	// shipped to S3 via UploadPart can't be unshipped. Deferring the
+	// flush by one call keeps every byte Truncate might need to discard
+	// sitting in buf, never committed.
+	if buf.Len() >= minPartSize {
+		if err := s.flushPart(ctx, id, info, buf); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := io.Copy(buf, r)
+	if err != nil {
+		return n, err
+	}
+
+	info.Offset = offset + n
+	if err := s.putInfo(ctx, id, info); err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// flushPart uploads whatever is currently buffered for id as the next part.
+func (s *s3store) flushPart(ctx context.Context, id string, info *s3Info, buf *bytes.Buffer) error {
+	partNumber := int32(len(info.Parts) + 1)
+
+	out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(id),
+		UploadId:   aws.String(info.UploadId),
+		PartNumber: aws.Int32(partNumber),
+		Body:       bytes.NewReader(buf.Bytes()),
+	})
+	if err != nil {
+		return err
+	}
+
+	info.Parts = append(info.Parts, types.CompletedPart{
+		ETag:       out.ETag,
+		PartNumber: aws.Int32(partNumber),
+	})
+	buf.Reset()
+	return nil
+}
+
+func (s *s3store) FinishUpload(id string, offset int64) error {
+	ctx := context.Background()
+
+	info, err := s.getInfo(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	buf := s.buffers[id]
+	delete(s.buffers, id)
+	s.mu.Unlock()
+
+	if buf != nil && buf.Len() > 0 {
+		if err := s.flushPart(ctx, id, info, buf); err != nil {
+			return err
+		}
+	}
+
+	// offset is the total length the caller knows was written; settle on
+	// it explicitly rather than trusting whatever WriteChunk last saw; it
+	// should always agree, but this is what Truncate-on-finish would do
+	// for a backend whose on-disk size can't be trusted (see filestore).
+	info.Offset = offset
+
+	if len(info.Parts) == 0 {
+		// S3 rejects CompleteMultipartUpload with zero parts, so a
+		// zero-byte upload is finished by aborting the multipart upload
+		// and writing an empty object directly.
+		if _, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(id),
+			UploadId: aws.String(info.UploadId),
+		}); err != nil {
+			return err
+		}
+		if _, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(id),
+		}); err != nil {
+			return err
+		}
+	} else if _, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(id),
+		UploadId:        aws.String(info.UploadId),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: info.Parts},
+	}); err != nil {
+		return err
+	}
+
+	info.UploadId = ""
+	return s.putInfo(ctx, id, info)
+}
+
+func (s *s3store) Terminate(id string) error {
+	ctx := context.Background()
+
+	info, err := s.getInfo(ctx, id)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.buffers, id)
+	s.mu.Unlock()
+
+	if info.UploadId != "" {
+		if _, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(id),
+			UploadId: aws.String(info.UploadId),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(id),
+	}); err != nil {
+		return err
+	}
+
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.infoKey(id)),
+	})
+	return err
+}
+
+func (s *s3store) Truncate(id string, length int64) error {
+	ctx := context.Background()
+
+	info, err := s.getInfo(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	committed := info.Offset
+	s.mu.Lock()
+	if buf, ok := s.buffers[id]; ok {
+		committed -= int64(buf.Len())
+	}
+	s.mu.Unlock()
+
+	if length < committed {
+		return fmt.Errorf("s3store: cannot truncate upload %s below already-committed part data (%d < %d)", id, length, committed)
+	}
+
+	s.mu.Lock()
+	if buf, ok := s.buffers[id]; ok {
+		buf.Truncate(int(length - committed))
+	}
+	s.mu.Unlock()
+
+	info.Offset = length
+	return s.putInfo(ctx, id, info)
+}
+
+// ExpireUploads aborts incomplete multipart uploads initiated at least
+// maxAge ago and removes their info objects. It walks every page
+// ListMultipartUploads returns, since a bucket can have more in-flight
+// uploads than fit on one page.
+func (s *s3store) ExpireUploads(maxAge time.Duration) error {
+	ctx := context.Background()
+	threshold := time.Now().Add(-maxAge)
+
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+	}
+
+	for {
+		out, err := s.client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return err
+		}
+
+		for _, u := range out.Uploads {
+			if u.Initiated == nil || u.Initiated.After(threshold) {
+				continue
+			}
+			if err := s.Terminate(aws.ToString(u.Key)); err != nil {
+				return err
+			}
+		}
+
+		if !aws.ToBool(out.IsTruncated) {
+			return nil
+		}
+		input.KeyMarker = out.NextKeyMarker
+		input.UploadIdMarker = out.NextUploadIdMarker
+	}
+}
+
+func (s *s3store) SetMetadata(id string, metadata map[string]string) error {
+	ctx := context.Background()
+
+	info, err := s.getInfo(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	info.Metadata = metadata
+	return s.putInfo(ctx, id, info)
+}
+
+func (s *s3store) GetMetadata(id string) (map[string]string, error) {
+	info, err := s.getInfo(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Metadata == nil {
+		return map[string]string{}, nil
+	}
+	return info.Metadata, nil
+}
+
+// isNotFound reports whether err is the "no such key" error GetObject
+// returns for a missing object.
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	var nsk *types.NoSuchKey
+	if errors.As(err, &nsk) {
+		return true
+	}
+	var nf *types.NotFound
+	return errors.As(err, &nf)
+}
@@ -0,0 +1,241 @@
+package caddy_resumable_uploads
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 is a minimal, in-memory stand-in for the subset of S3 (s3API)
+// that s3store depends on - just enough to drive its buffering/flush/
+// rollback logic under test without a real bucket.
+type fakeS3 struct {
+	mu sync.Mutex
+
+	objects      map[string][]byte
+	nextUploadId int
+	uploads      map[string]*fakeMultipartUpload
+}
+
+type fakeMultipartUpload struct {
+	key   string
+	parts map[int32][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]*fakeMultipartUpload),
+	}
+}
+
+func (f *fakeS3) GetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, ok := f.objects[aws.ToString(in.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (f *fakeS3) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	var body []byte
+	if in.Body != nil {
+		b, err := io.ReadAll(in.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	f.mu.Lock()
+	f.objects[aws.ToString(in.Key)] = body
+	f.mu.Unlock()
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(_ context.Context, in *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	delete(f.objects, aws.ToString(in.Key))
+	f.mu.Unlock()
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) CreateMultipartUpload(_ context.Context, in *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextUploadId++
+	id := fmt.Sprintf("fake-upload-%d", f.nextUploadId)
+	f.uploads[id] = &fakeMultipartUpload{key: aws.ToString(in.Key), parts: make(map[int32][]byte)}
+
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (f *fakeS3) UploadPart(_ context.Context, in *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	body, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	up, ok := f.uploads[aws.ToString(in.UploadId)]
+	if !ok {
+		return nil, fmt.Errorf("fakeS3: unknown upload id %q", aws.ToString(in.UploadId))
+	}
+	up.parts[aws.ToInt32(in.PartNumber)] = body
+
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", aws.ToInt32(in.PartNumber)))}, nil
+}
+
+func (f *fakeS3) CompleteMultipartUpload(_ context.Context, in *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	up, ok := f.uploads[aws.ToString(in.UploadId)]
+	if !ok {
+		return nil, fmt.Errorf("fakeS3: unknown upload id %q", aws.ToString(in.UploadId))
+	}
+
+	var body bytes.Buffer
+	for _, part := range in.MultipartUpload.Parts {
+		body.Write(up.parts[aws.ToInt32(part.PartNumber)])
+	}
+	f.objects[up.key] = body.Bytes()
+	delete(f.uploads, aws.ToString(in.UploadId))
+
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) AbortMultipartUpload(_ context.Context, in *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	delete(f.uploads, aws.ToString(in.UploadId))
+	f.mu.Unlock()
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) ListMultipartUploads(_ context.Context, _ *s3.ListMultipartUploadsInput, _ ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	return &s3.ListMultipartUploadsOutput{}, nil
+}
+
+// uploadedPartCount reports how many parts have actually been shipped for
+// whatever multipart upload is currently in progress, so tests can assert
+// on what was - and wasn't - made durable.
+func (f *fakeS3) uploadedPartCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, up := range f.uploads {
+		return len(up.parts)
+	}
+	return 0
+}
+
+func newTestS3store() (*s3store, *fakeS3) {
+	fake := newFakeS3()
+	return newS3store(fake, "test-bucket"), fake
+}
+
+func TestS3storeOverflowRollbackNeverLosesCommittedParts(t *testing.T) {
+	s, fake := newTestS3store()
+
+	if _, err := s.CreateUpload("upload-1"); err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+
+	// First chunk: well under minPartSize, buffered only.
+	if _, err := s.WriteChunk("upload-1", 0, strings.NewReader(strings.Repeat("a", 100))); err != nil {
+		t.Fatalf("WriteChunk (first): %v", err)
+	}
+	if got := fake.uploadedPartCount(); got != 0 {
+		t.Fatalf("uploaded parts after a sub-minPartSize chunk = %d, want 0", got)
+	}
+
+	// Second chunk pushes the buffer over minPartSize in the same call
+	// that - in the handler - would also be found to exceed the
+	// configured limit. Writing it must not ship a part before the
+	// caller gets a chance to decide whether to keep or roll it back.
+	second := strings.Repeat("b", minPartSize)
+	n, err := s.WriteChunk("upload-1", 100, strings.NewReader(second))
+	if err != nil {
+		t.Fatalf("WriteChunk (second): %v", err)
+	}
+	if n != int64(len(second)) {
+		t.Fatalf("WriteChunk (second) wrote %d bytes, want %d", n, len(second))
+	}
+	if got := fake.uploadedPartCount(); got != 0 {
+		t.Fatalf("uploaded parts after the oversized chunk = %d, want 0 (not yet confirmed)", got)
+	}
+
+	// The handler discovered an overflow and rolls the whole chunk back
+	// to the offset the upload was at before it started.
+	if err := s.Truncate("upload-1", 100); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if got := fake.uploadedPartCount(); got != 0 {
+		t.Fatalf("uploaded parts after Truncate = %d, want 0", got)
+	}
+
+	upload, _, err := s.GetUpload("upload-1")
+	if err != nil {
+		t.Fatalf("GetUpload: %v", err)
+	}
+	if upload.Offset != 100 {
+		t.Fatalf("Offset after Truncate = %d, want 100", upload.Offset)
+	}
+
+	// The store must still be usable afterwards.
+	if _, err := s.WriteChunk("upload-1", 100, strings.NewReader("tail")); err != nil {
+		t.Fatalf("WriteChunk after rollback: %v", err)
+	}
+	if err := s.FinishUpload("upload-1", 104); err != nil {
+		t.Fatalf("FinishUpload: %v", err)
+	}
+	// FinishUpload completes the multipart upload, leaving none in progress.
+	if got := fake.uploadedPartCount(); got != 0 {
+		t.Fatalf("uploaded parts after FinishUpload = %d, want 0 (upload completed)", got)
+	}
+
+	data, ok := fake.objects["upload-1"]
+	if !ok {
+		t.Fatal("completed object for upload-1 was never written")
+	}
+	if string(data) != strings.Repeat("a", 100)+"tail" {
+		t.Fatalf("completed object = %q, want %q", data, strings.Repeat("a", 100)+"tail")
+	}
+}
+
+func TestS3storeFlushesThePreviousBufferOnTheNextWrite(t *testing.T) {
+	s, fake := newTestS3store()
+
+	if _, err := s.CreateUpload("upload-2"); err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+
+	if _, err := s.WriteChunk("upload-2", 0, strings.NewReader(strings.Repeat("a", minPartSize))); err != nil {
+		t.Fatalf("WriteChunk (first): %v", err)
+	}
+	if got := fake.uploadedPartCount(); got != 0 {
+		t.Fatalf("uploaded parts immediately after filling the buffer = %d, want 0 (flush is deferred)", got)
+	}
+
+	if _, err := s.WriteChunk("upload-2", int64(minPartSize), strings.NewReader("more")); err != nil {
+		t.Fatalf("WriteChunk (second): %v", err)
+	}
+	if got := fake.uploadedPartCount(); got != 1 {
+		t.Fatalf("uploaded parts after the next write = %d, want 1 (the first chunk flushed)", got)
+	}
+}
@@ -0,0 +1,45 @@
+package caddy_resumable_uploads
+
+import "io"
+
+// Upload describes the state of a single resumable upload as tracked by a Store.
+type Upload struct {
+	ID         string
+	Offset     int64
+	IsComplete bool
+}
+
+// Store is the persistence layer behind the resumable upload handlers. It
+// abstracts where and how chunks end up so that UploadCreationHandler,
+// OffsetRetrievingHandler, UploadAppendingHandler and
+// UploadTerminationHandler don't need to know whether data lands on local
+// disk, in S3, or anywhere else.
+type Store interface {
+	// CreateUpload registers a brand new upload and returns its initial
+	// (empty) state. It must fail if id already exists.
+	CreateUpload(id string) (*Upload, error)
+	// GetUpload returns the current state of id. exists is false if no
+	// such upload was ever created.
+	GetUpload(id string) (upload *Upload, exists bool, err error)
+	// WriteChunk appends the contents of r to the upload at id, starting
+	// at offset, and returns the number of bytes written.
+	WriteChunk(id string, offset int64, r io.Reader) (int64, error)
+	// FinishUpload marks the upload as complete, performing any backend
+	// specific finalization (e.g. CompleteMultipartUpload on S3). offset
+	// is the total number of bytes the caller knows were written, and
+	// must be used to settle the upload's final length - the backend's
+	// own on-disk/remote size for id cannot be trusted, since it may
+	// include stale content left over from a previous use of the same id.
+	FinishUpload(id string, offset int64) error
+	// Terminate removes all data and metadata associated with id. It must
+	// not fail if id does not exist.
+	Terminate(id string) error
+	// Truncate discards any data written past length, rolling back a
+	// chunk that was rejected for exceeding the configured max size.
+	Truncate(id string, length int64) error
+	// SetMetadata persists the Upload-Metadata key/value pairs for id.
+	SetMetadata(id string, metadata map[string]string) error
+	// GetMetadata returns the metadata previously stored for id. It
+	// returns an empty, non-nil map if none was ever set.
+	GetMetadata(id string) (map[string]string, error)
+}